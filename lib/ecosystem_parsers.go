@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	registryTypeMaven    = "maven"
+	registryTypePyPI     = "pypi"
+	registryTypeNuGet    = "nuget"
+	registryTypeRubyGems = "rubygems"
+)
+
+const (
+	commandPublish  = "publish"
+	commandInstall  = "install"
+	commandDownload = "download"
+	commandDelete   = "delete"
+	commandSearch   = "search"
+)
+
+//MavenParser recognizes traffic against Gitea's Maven package registry
+//endpoint (`/maven/...`) and normalizes it into a RequestMeta. The Maven
+//repository layout encodes the coordinate in the path itself:
+///maven/<groupId-as-path>/<artifactId>/<version>/<artifactId>-<version>.<ext>
+func MavenParser(req *http.Request) (RequestMeta, error) {
+	if !strings.Contains(req.URL.Path, "/maven/") {
+		return RequestMeta{}, nil
+	}
+	if !strings.Contains(req.Header.Get("User-Agent"), "Apache-Maven") {
+		return RequestMeta{}, nil
+	}
+
+	meta := RequestMeta{
+		RegistryType: registryTypeMaven,
+		HasHit:       true,
+		Metadata:     make(map[string]string),
+	}
+	meta.Metadata["path"] = req.URL.String()
+	meta.Metadata["command"] = mavenCommand(req)
+
+	groupID, artifactID, version := parseMavenCoordinate(req.URL.Path)
+	meta.Metadata["groupId"] = groupID
+	meta.Metadata["artifactId"] = artifactID
+	meta.Metadata["version"] = version
+	meta.Metadata["package"] = artifactID
+
+	return meta, nil
+}
+
+func mavenCommand(req *http.Request) string {
+	switch req.Method {
+	case "PUT", "POST":
+		return commandPublish
+	case "DELETE":
+		return commandDelete
+	default:
+		return commandDownload
+	}
+}
+
+//parseMavenCoordinate extracts groupId/artifactId/version from a Maven
+//repository path of the form /maven/<group>/<path>/<artifactId>/<version>/<file>.
+func parseMavenCoordinate(path string) (groupID, artifactID, version string) {
+	trimmed := strings.Trim(strings.TrimPrefix(path, "/maven/"), "/")
+	parts := strings.Split(trimmed, "/")
+	if len(parts) < 3 {
+		return "", "", ""
+	}
+
+	groupParts := parts[:len(parts)-3]
+	artifactID = parts[len(parts)-3]
+	version = parts[len(parts)-2]
+	groupID = strings.Join(groupParts, ".")
+
+	return groupID, artifactID, version
+}
+
+//PyPIParser recognizes traffic against Gitea's PyPI package registry
+//endpoint (`/pypi/...`). Uploads arrive as a multipart/form-encoded POST
+//carrying `:action=file_upload`, while installs are plain GETs from pip.
+func PyPIParser(req *http.Request) (RequestMeta, error) {
+	if !strings.Contains(req.URL.Path, "/pypi/") {
+		return RequestMeta{}, nil
+	}
+
+	userAgent := req.Header.Get("User-Agent")
+	contentType := req.Header.Get("Content-Type")
+
+	isUpload := req.Method == "POST" &&
+		(strings.Contains(contentType, "application/x-www-form-urlencoded") || strings.Contains(contentType, "multipart/form-data"))
+	isPip := strings.Contains(userAgent, "pip")
+
+	if !isUpload && !isPip {
+		return RequestMeta{}, nil
+	}
+
+	meta := RequestMeta{
+		RegistryType: registryTypePyPI,
+		HasHit:       true,
+		Metadata:     make(map[string]string),
+	}
+	meta.Metadata["path"] = req.URL.String()
+
+	if isUpload {
+		meta.Metadata["command"] = commandPublish
+	} else if strings.Contains(req.URL.Path, "/simple/") {
+		meta.Metadata["command"] = commandInstall
+	} else {
+		meta.Metadata["command"] = commandSearch
+	}
+
+	meta.Metadata["package"] = pypiPackageFromPath(req.URL.Path)
+
+	return meta, nil
+}
+
+//pypiPackageFromPath pulls the package name out of a `/pypi/simple/<name>/`
+//or `/pypi/files/<name>/<version>/...` style path.
+func pypiPackageFromPath(path string) string {
+	trimmed := strings.Trim(strings.TrimPrefix(path, "/pypi/"), "/")
+	parts := strings.Split(trimmed, "/")
+	for i, part := range parts {
+		if (part == "simple" || part == "files") && i+1 < len(parts) {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+//NuGetParser recognizes traffic against Gitea's NuGet package registry
+//endpoint (`/nuget/...`).
+func NuGetParser(req *http.Request) (RequestMeta, error) {
+	if !strings.Contains(req.URL.Path, "/nuget/") {
+		return RequestMeta{}, nil
+	}
+	if !strings.Contains(req.Header.Get("User-Agent"), "NuGet") {
+		return RequestMeta{}, nil
+	}
+
+	meta := RequestMeta{
+		RegistryType: registryTypeNuGet,
+		HasHit:       true,
+		Metadata:     make(map[string]string),
+	}
+	meta.Metadata["path"] = req.URL.String()
+	meta.Metadata["apiVersion"] = req.URL.Query().Get("api-version")
+
+	switch {
+	case req.Method == "PUT" && req.Header.Get("X-NuGet-ApiKey") != "":
+		meta.Metadata["command"] = commandPublish
+	case req.Method == "DELETE":
+		meta.Metadata["command"] = commandDelete
+	case strings.Contains(req.URL.Path, "/query"):
+		meta.Metadata["command"] = commandSearch
+	default:
+		meta.Metadata["command"] = commandDownload
+	}
+
+	return meta, nil
+}
+
+//RubyGemsParser recognizes traffic against Gitea's RubyGems package
+//registry endpoint (`/rubygems/...`).
+func RubyGemsParser(req *http.Request) (RequestMeta, error) {
+	if !strings.Contains(req.URL.Path, "/rubygems/") {
+		return RequestMeta{}, nil
+	}
+	if !strings.Contains(req.Header.Get("User-Agent"), "gem") {
+		return RequestMeta{}, nil
+	}
+
+	meta := RequestMeta{
+		RegistryType: registryTypeRubyGems,
+		HasHit:       true,
+		Metadata:     make(map[string]string),
+	}
+	meta.Metadata["path"] = req.URL.String()
+
+	switch {
+	case req.Method == "POST" && strings.Contains(req.URL.Path, "/api/v1/gems"):
+		meta.Metadata["command"] = commandPublish
+	case req.Method == "DELETE":
+		meta.Metadata["command"] = commandDelete
+	case strings.Contains(req.URL.Path, "/gems/"):
+		meta.Metadata["command"] = commandDownload
+		_, gemFile := path.Split(req.URL.Path)
+		meta.Metadata["package"] = strings.TrimSuffix(gemFile, ".gem")
+	default:
+		meta.Metadata["command"] = commandSearch
+	}
+
+	return meta, nil
+}
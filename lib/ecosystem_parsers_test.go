@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPyPIParserUploadRequiresPost(t *testing.T) {
+	cases := []struct {
+		name        string
+		method      string
+		contentType string
+		wantHit     bool
+		wantCommand string
+	}{
+		{
+			name:        "POST multipart is a publish",
+			method:      "POST",
+			contentType: "multipart/form-data; boundary=x",
+			wantHit:     true,
+			wantCommand: commandPublish,
+		},
+		{
+			name:        "POST form-urlencoded is a publish",
+			method:      "POST",
+			contentType: "application/x-www-form-urlencoded",
+			wantHit:     true,
+			wantCommand: commandPublish,
+		},
+		{
+			name:        "GET with a multipart Content-Type is not a publish",
+			method:      "GET",
+			contentType: "multipart/form-data; boundary=x",
+			wantHit:     false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "http://example.com/pypi/simple/foo/", nil)
+			req.Header.Set("Content-Type", tc.contentType)
+
+			meta, err := PyPIParser(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if meta.HasHit != tc.wantHit {
+				t.Fatalf("HasHit = %v, want %v", meta.HasHit, tc.wantHit)
+			}
+			if tc.wantHit && meta.Metadata["command"] != tc.wantCommand {
+				t.Fatalf("command = %q, want %q", meta.Metadata["command"], tc.wantCommand)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const priorityCatalog = 5
+
+//Bounds on how far FollowPaginated will walk a Link: rel="next" chain, so a
+//misbehaving or hostile upstream (an unbounded or cyclic chain) can't make
+//the proxy hot path loop or grow without limit.
+const (
+	maxPaginationPages = 1000
+	maxPaginationItems = 100000
+)
+
+var linkNextRE = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+//HarborCatalogParser is a sibling of HarborParser that recognizes Docker
+//Distribution's paginated catalog and tag-list endpoints
+//(`/v2/_catalog`, `/v2/<name>/tags/list`) and records the pagination
+//parameters in RequestMeta.Metadata so a caller can later walk the full
+//result set with FollowPaginated.
+func HarborCatalogParser(req *http.Request) (RequestMeta, error) {
+	path := req.URL.Path
+	isCatalog := path == "/v2/_catalog"
+	isTagsList := strings.HasPrefix(path, "/v2/") && strings.HasSuffix(path, "/tags/list")
+	if !isCatalog && !isTagsList {
+		return RequestMeta{}, nil
+	}
+
+	meta := RequestMeta{
+		RegistryType: registryTypeImage,
+		HasHit:       true,
+		Metadata:     make(map[string]string),
+	}
+	meta.Metadata["path"] = req.URL.String()
+	if isCatalog {
+		meta.Metadata["command"] = "catalog"
+	} else {
+		meta.Metadata["command"] = "tags-list"
+	}
+
+	n := req.URL.Query().Get("n")
+	meta.Metadata["n"] = n
+	meta.Metadata["last"] = req.URL.Query().Get("last")
+	//Per the Distribution spec, the upstream only paginates (and returns a
+	//Link header) when the caller asked for a bounded page via "n".
+	meta.Metadata["expectLink"] = strconv.FormatBool(n != "")
+
+	return meta, nil
+}
+
+//FollowPaginated issues req via client and, if the response carries a
+//`Link: <...>; rel="next"` header, keeps following it and merging the
+//`repositories`/`tags` array from each page, matching Docker Distribution's
+//paginated catalog/tag-list protocol. It returns the stitched-together JSON
+//document as if the whole list had come back in a single response.
+func FollowPaginated(ctx context.Context, client *http.Client, req *http.Request) ([]byte, error) {
+	var combined map[string]json.RawMessage
+	var listKey string
+	var items []json.RawMessage
+
+	next := req
+	for pageCount := 0; next != nil; pageCount++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if pageCount >= maxPaginationPages {
+			return nil, fmt.Errorf("pagination exceeded %d pages, aborting", maxPaginationPages)
+		}
+
+		page, linkHeader, err := fetchPage(ctx, client, next)
+		if err != nil {
+			return nil, err
+		}
+
+		if combined == nil {
+			combined = page
+			listKey = paginatedListKey(page)
+		}
+
+		if listKey != "" {
+			var pageItems []json.RawMessage
+			if raw, ok := page[listKey]; ok {
+				if err := json.Unmarshal(raw, &pageItems); err != nil {
+					return nil, err
+				}
+				items = append(items, pageItems...)
+				if len(items) > maxPaginationItems {
+					return nil, fmt.Errorf("pagination exceeded %d accumulated items, aborting", maxPaginationItems)
+				}
+			}
+		}
+
+		nextURL, ok := parseNextLink(linkHeader)
+		if !ok {
+			break
+		}
+
+		parsed, err := req.URL.Parse(nextURL)
+		if err != nil {
+			return nil, err
+		}
+
+		cloned := next.Clone(ctx)
+		cloned.URL = parsed
+		cloned.Host = parsed.Host
+		next = cloned
+	}
+
+	if listKey != "" {
+		merged, err := json.Marshal(items)
+		if err != nil {
+			return nil, err
+		}
+		combined[listKey] = merged
+	}
+
+	return json.Marshal(combined)
+}
+
+func fetchPage(ctx context.Context, client *http.Client, req *http.Request) (map[string]json.RawMessage, string, error) {
+	resp, err := client.Do(req.Clone(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var page map[string]json.RawMessage
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, "", err
+	}
+
+	return page, resp.Header.Get("Link"), nil
+}
+
+func paginatedListKey(page map[string]json.RawMessage) string {
+	if _, ok := page["repositories"]; ok {
+		return "repositories"
+	}
+	if _, ok := page["tags"]; ok {
+		return "tags"
+	}
+
+	return ""
+}
+
+func parseNextLink(header string) (string, bool) {
+	if header == "" {
+		return "", false
+	}
+
+	m := linkNextRE.FindStringSubmatch(header)
+	if m == nil {
+		return "", false
+	}
+
+	return m[1], true
+}
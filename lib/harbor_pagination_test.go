@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//TestFollowPaginatedMergesTwoPages exercises the Docker Distribution
+//pagination protocol end to end: the first page's Link: rel="next" header
+//must be followed and its repositories array merged with the second page's.
+func TestFollowPaginatedMergesTwoPages(t *testing.T) {
+	var nextURL string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/_catalog", func(w http.ResponseWriter, r *http.Request) {
+		last := r.URL.Query().Get("last")
+		if last == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL))
+			w.Write([]byte(`{"repositories": ["repo-a", "repo-b"]}`))
+			return
+		}
+		if last != "repo-b" {
+			t.Errorf("second page requested with last=%q, want %q", last, "repo-b")
+		}
+		w.Write([]byte(`{"repositories": ["repo-c"]}`))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	nextURL = server.URL + "/v2/_catalog?n=2&last=repo-b"
+
+	req := httptest.NewRequest("GET", server.URL+"/v2/_catalog?n=2", nil)
+	req.RequestURI = ""
+
+	body, err := FollowPaginated(context.Background(), server.Client(), req)
+	if err != nil {
+		t.Fatalf("FollowPaginated: %v", err)
+	}
+
+	var got struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("unmarshaling merged body: %v", err)
+	}
+
+	want := []string{"repo-a", "repo-b", "repo-c"}
+	if len(got.Repositories) != len(want) {
+		t.Fatalf("repositories = %v, want %v", got.Repositories, want)
+	}
+	for i := range want {
+		if got.Repositories[i] != want[i] {
+			t.Fatalf("repositories = %v, want %v", got.Repositories, want)
+		}
+	}
+}
+
+//TestFollowPaginatedSinglePageNoLink guards the no-pagination case: with no
+//Link header, FollowPaginated must return the single page unmodified.
+func TestFollowPaginatedSinglePageNoLink(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"repositories": ["only-repo"]}`))
+	}))
+	defer server.Close()
+
+	req := httptest.NewRequest("GET", server.URL+"/v2/_catalog", nil)
+	req.RequestURI = ""
+
+	body, err := FollowPaginated(context.Background(), server.Client(), req)
+	if err != nil {
+		t.Fatalf("FollowPaginated: %v", err)
+	}
+
+	var got struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("unmarshaling body: %v", err)
+	}
+	if len(got.Repositories) != 1 || got.Repositories[0] != "only-repo" {
+		t.Fatalf("repositories = %v, want [only-repo]", got.Repositories)
+	}
+}
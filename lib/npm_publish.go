@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+//IntegrityError reports that a decoded npm publish tarball does not match
+//the shasum/integrity digest the registry claims for it, so the publish
+//can be rejected before it ever reaches Verdaccio/Harbor.
+type IntegrityError struct {
+	Package  string
+	Version  string
+	Field    string //"shasum" or "integrity"
+	Expected string
+	Actual   string
+}
+
+//Error ...
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("npm publish integrity check failed for %s@%s: %s mismatch (expected %s, got %s)",
+		e.Package, e.Version, e.Field, e.Expected, e.Actual)
+}
+
+//Terminal makes IntegrityError a TerminalError: a tampered tarball must
+//reject the publish outright rather than let ParserChain fall through to
+//Harbor's never-erroring default.
+func (e *IntegrityError) Terminal() bool {
+	return true
+}
+
+type npmAttachment struct {
+	ContentType string `json:"content_type"`
+	Data        string `json:"data"`
+	Length      int    `json:"length"`
+}
+
+type npmDist struct {
+	Shasum    string `json:"shasum"`
+	Integrity string `json:"integrity"`
+	Tarball   string `json:"tarball"`
+}
+
+type npmVersionMeta struct {
+	Name            string            `json:"name"`
+	Version         string            `json:"version"`
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+	Keywords        []string          `json:"keywords"`
+	License         string            `json:"license"`
+	Repository      struct {
+		URL string `json:"url"`
+	} `json:"repository"`
+	Dist npmDist `json:"dist"`
+}
+
+type npmPublishPayload struct {
+	Name     string `json:"name"`
+	DistTags struct {
+		Latest string `json:"latest"`
+	} `json:"dist-tags"`
+	Versions    map[string]npmVersionMeta `json:"versions"`
+	Attachments map[string]npmAttachment  `json:"_attachments"`
+}
+
+//inspectNpmPublish fully decodes an npm publish payload: it picks the
+//attachment for dist-tags.latest, verifies its shasum/integrity digests
+//against the version metadata the registry itself claims, and extracts the
+//package.json fields interesting to callers out of the tarball. It returns
+//an *IntegrityError if the decoded tarball doesn't match the claimed
+//digests.
+func inspectNpmPublish(buf []byte) (map[string]string, error) {
+	payload := &npmPublishPayload{}
+	if err := json.Unmarshal(buf, payload); err != nil {
+		return nil, err
+	}
+
+	meta := map[string]string{
+		"name": payload.Name,
+	}
+
+	version := payload.DistTags.Latest
+	vmeta, ok := payload.Versions[version]
+	if !ok {
+		//Nothing more we can verify without the matching version entry.
+		return meta, nil
+	}
+	meta["version"] = version
+
+	tarball, err := decodeAttachment(payload.Attachments, vmeta.Name, version)
+	if err != nil {
+		return nil, err
+	}
+	if tarball == nil {
+		return meta, nil
+	}
+
+	shasum := sha1.Sum(tarball)
+	shasumHex := hex.EncodeToString(shasum[:])
+	if vmeta.Dist.Shasum != "" && vmeta.Dist.Shasum != shasumHex {
+		return nil, &IntegrityError{
+			Package:  vmeta.Name,
+			Version:  version,
+			Field:    "shasum",
+			Expected: vmeta.Dist.Shasum,
+			Actual:   shasumHex,
+		}
+	}
+
+	sum512 := sha512.Sum512(tarball)
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum512[:])
+	if vmeta.Dist.Integrity != "" && vmeta.Dist.Integrity != integrity {
+		return nil, &IntegrityError{
+			Package:  vmeta.Name,
+			Version:  version,
+			Field:    "integrity",
+			Expected: vmeta.Dist.Integrity,
+			Actual:   integrity,
+		}
+	}
+
+	meta["tarball_size"] = fmt.Sprintf("%d", len(tarball))
+	meta["integrity"] = integrity
+
+	pkgJSON, err := readPackageJSON(tarball)
+	if err != nil {
+		return nil, err
+	}
+	if pkgJSON != nil {
+		applyPackageJSON(meta, pkgJSON)
+	}
+
+	return meta, nil
+}
+
+//decodeAttachment finds the attachment for name@version among the
+//payload's _attachments and base64-decodes it. npm publishes exactly one
+//attachment per version, keyed `<name>-<version>.tgz`; if that exact key
+//isn't present we fall back to one of the entries present, and which one
+//is not defined when there are several.
+func decodeAttachment(attachments map[string]npmAttachment, name, version string) ([]byte, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	key := fmt.Sprintf("%s-%s.tgz", name, version)
+	attachment, ok := attachments[key]
+	if !ok {
+		for _, a := range attachments {
+			attachment = a
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	return base64.StdEncoding.DecodeString(attachment.Data)
+}
+
+//readPackageJSON decodes a gzipped tar and returns the contents of
+//package/package.json, or nil if the tarball doesn't contain one.
+func readPackageJSON(tarball []byte) (*npmVersionMeta, error) {
+	gz, err := gzip.NewReader(strings.NewReader(string(tarball)))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Name != "package/package.json" {
+			continue
+		}
+
+		raw, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		pkgJSON := &npmVersionMeta{}
+		if err := json.Unmarshal(raw, pkgJSON); err != nil {
+			return nil, err
+		}
+
+		return pkgJSON, nil
+	}
+}
+
+func applyPackageJSON(meta map[string]string, pkgJSON *npmVersionMeta) {
+	if pkgJSON.License != "" {
+		meta["license"] = pkgJSON.License
+	}
+	if pkgJSON.Repository.URL != "" {
+		meta["repository_url"] = pkgJSON.Repository.URL
+	}
+	if len(pkgJSON.Keywords) > 0 {
+		meta["keywords"] = strings.Join(pkgJSON.Keywords, ",")
+	}
+	if deps, err := json.Marshal(pkgJSON.Dependencies); err == nil && len(pkgJSON.Dependencies) > 0 {
+		meta["dependencies"] = string(deps)
+	}
+	if devDeps, err := json.Marshal(pkgJSON.DevDependencies); err == nil && len(pkgJSON.DevDependencies) > 0 {
+		meta["devDependencies"] = string(devDeps)
+	}
+}
@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func buildNpmTarGz(t *testing.T, pkgJSON []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	hdr := &tar.Header{Name: "package/package.json", Mode: 0644, Size: int64(len(pkgJSON))}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatalf("writing tar header: %v", err)
+	}
+	if _, err := tw.Write(pkgJSON); err != nil {
+		t.Fatalf("writing tar body: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("closing gzip writer: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func tamperedNpmPublishPayload(t *testing.T) []byte {
+	t.Helper()
+
+	tarball := buildNpmTarGz(t, []byte(`{"name":"foo","version":"1.0.0"}`))
+	data := base64.StdEncoding.EncodeToString(tarball)
+
+	return []byte(fmt.Sprintf(`{
+		"name": "foo",
+		"dist-tags": {"latest": "1.0.0"},
+		"versions": {
+			"1.0.0": {
+				"name": "foo",
+				"version": "1.0.0",
+				"dist": {"shasum": "0000000000000000000000000000000000000000"}
+			}
+		},
+		"_attachments": {
+			"foo-1.0.0.tgz": {"content_type": "application/octet-stream", "data": %q, "length": %d}
+		}
+	}`, data, len(tarball)))
+}
+
+func corruptNpmPublishPayload() []byte {
+	return []byte(`{
+		"name": "foo",
+		"dist-tags": {"latest": "1.0.0"},
+		"versions": {
+			"1.0.0": {
+				"name": "foo",
+				"version": "1.0.0",
+				"dist": {"shasum": "", "integrity": ""}
+			}
+		},
+		"_attachments": {
+			"foo-1.0.0.tgz": {"content_type": "application/octet-stream", "data": "not-valid-base64!!!", "length": 3}
+		}
+	}`)
+}
+
+func TestInspectNpmPublishDecodeError(t *testing.T) {
+	_, err := inspectNpmPublish(corruptNpmPublishPayload())
+	if err == nil {
+		t.Fatal("expected a decode error, got nil")
+	}
+	if _, ok := err.(*IntegrityError); ok {
+		t.Fatalf("expected a plain decode error, got *IntegrityError: %v", err)
+	}
+}
+
+//TestNpmParserRestoresBodyOnDecodeError guards against a decode failure
+//(bad base64, corrupt gzip/tar, malformed package.json, ...) silently
+//discarding req.Body - NpmParser drains it to inspect the publish, and
+//must put it back before returning, even on an error path.
+func TestNpmParserRestoresBodyOnDecodeError(t *testing.T) {
+	payload := corruptNpmPublishPayload()
+	req := httptest.NewRequest("PUT", "http://example.com/foo", bytes.NewReader(payload))
+	req.Header.Set("User-Agent", "npm/7.0.0 node/v14")
+	req.Header.Set("Referer", "publish foo")
+
+	if _, err := NpmParser(req); err == nil {
+		t.Fatal("expected NpmParser to return a decode error")
+	}
+
+	remaining, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("reading restored body: %v", err)
+	}
+	if !bytes.Equal(remaining, payload) {
+		t.Fatalf("req.Body after a decode error = %q, want the original payload %q", remaining, payload)
+	}
+}
+
+//TestParserChainFallsThroughWithBodyIntactOnDecodeError reproduces the
+//maintainer-reported bug end to end: a malformed npm publish must still
+//fall through to HarborParser with the original body available to proxy
+//upstream, not an empty one.
+func TestParserChainFallsThroughWithBodyIntactOnDecodeError(t *testing.T) {
+	payload := corruptNpmPublishPayload()
+	req := httptest.NewRequest("PUT", "http://example.com/foo", bytes.NewReader(payload))
+	req.Header.Set("User-Agent", "npm/7.0.0 node/v14")
+	req.Header.Set("Referer", "publish foo")
+
+	pc := &ParserChain{}
+	if err := pc.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	meta, err := pc.Parse(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if meta.RegistryType != registryTypeImage {
+		t.Fatalf("RegistryType = %q, want %q (Harbor fall-through)", meta.RegistryType, registryTypeImage)
+	}
+
+	remaining, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("reading req.Body: %v", err)
+	}
+	if !bytes.Equal(remaining, payload) {
+		t.Fatalf("req.Body after fall-through = %q, want the original payload %q", remaining, payload)
+	}
+}
+
+func TestInspectNpmPublishIntegrityMismatch(t *testing.T) {
+	_, err := inspectNpmPublish(tamperedNpmPublishPayload(t))
+	if err == nil {
+		t.Fatal("expected an integrity error, got nil")
+	}
+
+	integrityErr, ok := err.(*IntegrityError)
+	if !ok {
+		t.Fatalf("expected *IntegrityError, got %T: %v", err, err)
+	}
+	if integrityErr.Field != "shasum" {
+		t.Fatalf("Field = %q, want %q", integrityErr.Field, "shasum")
+	}
+	if !integrityErr.Terminal() {
+		t.Fatal("IntegrityError must be terminal so the chain doesn't fall through to Harbor")
+	}
+}
+
+func TestParserChainRejectsTamperedPublish(t *testing.T) {
+	req := httptest.NewRequest("PUT", "http://example.com/foo", bytes.NewReader(tamperedNpmPublishPayload(t)))
+	req.Header.Set("User-Agent", "npm/7.0.0 node/v14")
+	req.Header.Set("Referer", "publish foo")
+
+	pc := &ParserChain{}
+	if err := pc.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	meta, err := pc.Parse(context.Background(), req)
+	if err == nil {
+		t.Fatalf("expected ParserChain.Parse to reject the tampered publish, got meta %+v", meta)
+	}
+	if _, ok := err.(*IntegrityError); !ok {
+		t.Fatalf("expected *IntegrityError to propagate out of Parse, got %T: %v", err, err)
+	}
+}
@@ -2,11 +2,13 @@ package lib
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -16,17 +18,67 @@ const (
 	registryTypeImage = "harbor"
 )
 
+const (
+	priorityEcosystem = 10
+	priorityDefault   = 0
+)
+
+//defaultMaxBodyBuffer bounds how much of req.Body ParserChain.Parse will
+//buffer up-front when running parsers concurrently.
+const defaultMaxBodyBuffer = 10 << 20 // 10MiB
+
 //RequestMeta ...
 type RequestMeta struct {
 	RegistryType string
 	HasHit       bool
 	Metadata     map[string]string
+
+	//BodyReplacement, when non-nil, is written back onto the original
+	//*http.Request's Body/ContentLength/Content-Length by ParserChain.Parse
+	//once this meta is chosen as the winning hit. Under concurrent
+	//evaluation each parser only ever sees its own clone of req, so changes
+	//a parser makes to the req it was given never reach the original
+	//request; BodyReplacement is how a parser hands a body back.
+	BodyReplacement []byte
+}
+
+//TerminalError is an error a Parser can return to stop ParserChain.Parse
+//from falling through to the rest of the chain (and eventually Harbor's
+//never-erroring default). Use it for errors that mean the request itself
+//is invalid - e.g. *IntegrityError - rather than "this parser doesn't
+//recognize this request".
+type TerminalError interface {
+	error
+	Terminal() bool
+}
+
+func asTerminalError(err error) (TerminalError, bool) {
+	te, ok := err.(TerminalError)
+	if !ok || !te.Terminal() {
+		return nil, false
+	}
+
+	return te, true
 }
 
-type npmPackMeta struct {
-	Tags struct {
-		Latest string `json:"latest"`
-	} `json:"dist-tags"`
+//ParseError aggregates the error returned by each parser that was tried,
+//keyed by parser name, so callers can tell which parsers failed instead of
+//parsing a semicolon-joined string.
+type ParseError map[string]error
+
+//Error ...
+func (pe ParseError) Error() string {
+	if len(pe) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(pe))
+	for name, err := range pe {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, err.Error()))
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, "; ")
 }
 
 //Parser ...
@@ -57,17 +109,29 @@ func NpmParser(req *http.Request) (RequestMeta, error) {
 				if err != nil {
 					return RequestMeta{}, err
 				}
-				npmMetaJSON := &npmPackMeta{}
-				if err := json.Unmarshal(buf, npmMetaJSON); err != nil {
-					return RequestMeta{}, err
-				}
 
-				meta.Metadata["extra"] = npmMetaJSON.Tags.Latest
-
-				body := ioutil.NopCloser(bytes.NewBuffer(buf))
-				req.Body = body
+				//req is either the real request (sequential mode) or this
+				//parser's private clone (concurrent mode) - either way, it's
+				//ours to restore immediately after draining it, so that a
+				//decode error below doesn't leave the body empty for
+				//whichever parser or proxy sees it next.
+				req.Body = ioutil.NopCloser(bytes.NewReader(buf))
 				req.ContentLength = int64(len(buf))
 				req.Header.Set("Content-Length", strconv.Itoa(len(buf)))
+
+				publishMeta, err := inspectNpmPublish(buf)
+				if err != nil {
+					return RequestMeta{}, err
+				}
+				for k, v := range publishMeta {
+					meta.Metadata[k] = v
+				}
+				meta.Metadata["extra"] = publishMeta["version"]
+
+				//Hand the (already-restored) body back via BodyReplacement
+				//too, so ParserChain.Parse reapplies the same bytes onto the
+				//original request once this meta is chosen as the hit.
+				meta.BodyReplacement = buf
 			}
 
 			return meta, nil
@@ -86,70 +150,263 @@ func HarborParser(req *http.Request) (RequestMeta, error) {
 	}, nil
 }
 
+//parserEntry pairs a registered Parser with the name and priority it was
+//registered under.
+type parserEntry struct {
+	Name     string
+	Parser   Parser
+	Priority int
+}
+
 //ParserChain ...
 type ParserChain struct {
-	head *parserWrapper
-	tail *parserWrapper
+	entries []parserEntry
+
+	//closers holds anything registered parsers opened (e.g. the gRPC
+	//connections RegisterRemote dials) so Close can release them.
+	closers []io.Closer
+
+	//Concurrent, when true, makes Parse fan the request out to every
+	//registered parser at once instead of walking them in priority order.
+	Concurrent bool
+
+	//MaxBodyBuffer bounds how many bytes of req.Body Parse will buffer when
+	//running concurrently. Zero means defaultMaxBodyBuffer.
+	MaxBodyBuffer int64
 }
 
-//ParserWrapper ...
-type parserWrapper struct {
-	parser Parser
-	next   *parserWrapper
+//Close releases anything registered parsers opened, such as the gRPC
+//connections RegisterRemote dials. Callers that rebuild a ParserChain (e.g.
+//on config reload) should Close the old one first to avoid leaking
+//connections.
+func (pc *ParserChain) Close() error {
+	var firstErr error
+	for _, c := range pc.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	pc.closers = nil
+
+	return firstErr
 }
 
-//Parse ...
-func (pc *ParserChain) Parse(req *http.Request) (RequestMeta, error) {
-	if pc.head == nil {
+//Parse evaluates req against the registered parsers and returns the meta
+//of the highest-priority hit. In sequential mode (the default) parsers run
+//in priority order and the first hit wins. In concurrent mode all parsers
+//run in parallel against clones of req; as soon as the winner is known (a
+//hit whose every predecessor in priority order has already reported without
+//a hit), ctx is cancelled to let any parser that respects request-scoped
+//cancellation give up early.
+func (pc *ParserChain) Parse(ctx context.Context, req *http.Request) (RequestMeta, error) {
+	if len(pc.entries) == 0 {
 		return RequestMeta{}, errors.New("no parsers")
 	}
 
-	var errs []string
-	p := pc.head
-	for p != nil && p.parser != nil {
-		if meta, err := p.parser(req); err != nil {
-			errs = append(errs, err.Error())
-		} else {
-			if meta.HasHit {
-				return meta, nil
+	if pc.Concurrent {
+		return pc.parseConcurrent(ctx, req)
+	}
+
+	return pc.parseSequential(req)
+}
+
+func (pc *ParserChain) parseSequential(req *http.Request) (RequestMeta, error) {
+	errs := ParseError{}
+	for _, entry := range pc.orderedEntries() {
+		meta, err := entry.Parser(req)
+		if err != nil {
+			if _, ok := asTerminalError(err); ok {
+				return RequestMeta{}, err
 			}
+			errs[entry.Name] = err
+			continue
 		}
 
-		//next
-		p = p.next
+		if meta.HasHit {
+			applyBodyReplacement(req, meta)
+			return meta, nil
+		}
 	}
 
-	//No hit
-	return RequestMeta{}, fmt.Errorf("%s:%s", "no hit", strings.Join(errs, ";"))
+	return RequestMeta{}, noHitError(errs)
+}
+
+type parseResult struct {
+	index int
+	entry parserEntry
+	meta  RequestMeta
+	err   error
+}
+
+//parseConcurrent evaluates every parser in parallel but still has to
+//resolve ties and errors exactly the way parseSequential would: entries is
+//already priority-descending with registration order breaking ties
+//(orderedEntries), so whichever entry comes first in that slice and
+//reports a hit is the winner, regardless of which goroutine happens to
+//finish first. next walks that preference order, only ever advancing past
+//an index once its result is in hand, so the outcome never depends on
+//completion order - only latency does.
+func (pc *ParserChain) parseConcurrent(ctx context.Context, req *http.Request) (RequestMeta, error) {
+	entries := pc.orderedEntries()
+
+	bodyBuf, err := bufferBody(req, pc.maxBodyBuffer())
+	if err != nil {
+		return RequestMeta{}, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan parseResult, len(entries))
+	for i, entry := range entries {
+		i, entry := i, entry
+		go func() {
+			clone := req.Clone(ctx)
+			if bodyBuf != nil {
+				clone.Body = ioutil.NopCloser(bytes.NewReader(bodyBuf))
+			}
+			meta, err := entry.Parser(clone)
+			results <- parseResult{index: i, entry: entry, meta: meta, err: err}
+		}()
+	}
+
+	received := make([]*parseResult, len(entries))
+	errs := ParseError{}
+	next := 0
+
+	for count := 0; count < len(entries); count++ {
+		res := <-results
+		received[res.index] = &res
+
+		for next < len(entries) && received[next] != nil {
+			r := received[next]
+			if r.err != nil {
+				if _, ok := asTerminalError(r.err); ok {
+					cancel()
+					return RequestMeta{}, r.err
+				}
+				errs[r.entry.Name] = r.err
+				next++
+				continue
+			}
+
+			if r.meta.HasHit {
+				cancel()
+				applyBodyReplacement(req, r.meta)
+				return r.meta, nil
+			}
+
+			next++
+		}
+	}
+
+	return RequestMeta{}, noHitError(errs)
+}
+
+func (pc *ParserChain) orderedEntries() []parserEntry {
+	entries := make([]parserEntry, len(pc.entries))
+	copy(entries, pc.entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Priority > entries[j].Priority
+	})
+
+	return entries
+}
+
+func (pc *ParserChain) maxBodyBuffer() int64 {
+	if pc.MaxBodyBuffer > 0 {
+		return pc.MaxBodyBuffer
+	}
+
+	return defaultMaxBodyBuffer
+}
+
+func noHitError(errs ParseError) error {
+	if len(errs) == 0 {
+		return errors.New("no hit")
+	}
+
+	return fmt.Errorf("no hit: %w", errs)
+}
+
+//bufferBody reads req.Body into memory (bounded by max), rewinds req.Body
+//so it remains proxyable, and returns the buffered bytes so callers can
+//hand each parser clone its own independent reader.
+func bufferBody(req *http.Request, max int64) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > max {
+		return nil, fmt.Errorf("request body exceeds max buffer size of %d bytes", max)
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(buf))
+
+	return buf, nil
+}
+
+//applyBodyReplacement writes a winning parser's BodyReplacement back onto
+//the original request, if it set one.
+func applyBodyReplacement(req *http.Request, meta RequestMeta) {
+	if meta.BodyReplacement == nil {
+		return
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(meta.BodyReplacement))
+	req.ContentLength = int64(len(meta.BodyReplacement))
+	req.Header.Set("Content-Length", strconv.Itoa(len(meta.BodyReplacement)))
 }
 
 //Init ...
 func (pc *ParserChain) Init() error {
-	pc.head = nil
-	pc.tail = nil
+	if err := pc.Close(); err != nil {
+		return err
+	}
+	pc.entries = nil
 
-	if err := pc.Register(NpmParser); err != nil {
+	ecosystemParsers := []struct {
+		name   string
+		parser Parser
+	}{
+		{"npm", NpmParser},
+		{"maven", MavenParser},
+		{"pypi", PyPIParser},
+		{"nuget", NuGetParser},
+		{"rubygems", RubyGemsParser},
+	}
+
+	for _, ep := range ecosystemParsers {
+		if err := pc.RegisterWithPriority(ep.name, ep.parser, priorityEcosystem); err != nil {
+			return err
+		}
+	}
+
+	//Catalog/tags-list pagination is a more specific match than the Harbor
+	//default, but still less specific than the package-ecosystem parsers.
+	if err := pc.RegisterWithPriority("harbor-catalog", HarborCatalogParser, priorityCatalog); err != nil {
 		return err
 	}
 
-	return pc.Register(HarborParser)
+	//Harbor is the fall-through default and must be registered at the
+	//lowest priority so every other parser above gets a chance to claim the
+	//request first.
+	return pc.RegisterWithPriority("harbor", HarborParser, priorityDefault)
 }
 
-//Register ...
-func (pc *ParserChain) Register(parser Parser) error {
+//RegisterWithPriority registers parser under name at the given priority.
+//Higher priority parsers are evaluated - and win ties against lower
+//priority hits - before lower priority ones.
+func (pc *ParserChain) RegisterWithPriority(name string, parser Parser, priority int) error {
 	if parser == nil {
 		return errors.New("nil parser")
 	}
 
-	if pc.head == nil {
-		pc.head = &parserWrapper{parser, nil}
-		pc.tail = pc.head
-
-		return nil
-	}
-
-	pc.tail.next = &parserWrapper{parser, nil}
-	pc.tail = pc.tail.next
+	pc.entries = append(pc.entries, parserEntry{Name: name, Parser: parser, Priority: priority})
 
 	return nil
 }
@@ -0,0 +1,75 @@
+package lib
+
+import "fmt"
+
+//ParserConfigEntry describes one entry of a `parsers:` configuration list,
+//e.g.:
+//
+//  parsers:
+//    - name: npm
+//    - name: harbor
+//    - remote: "localhost:9000"
+//    - plugin: "/opt/parsers/maven.so"
+//
+//Callers unmarshal their config format (YAML, JSON, ...) into a slice of
+//these and pass it to InitFromConfig.
+type ParserConfigEntry struct {
+	Name     string `yaml:"name,omitempty" json:"name,omitempty"`
+	Remote   string `yaml:"remote,omitempty" json:"remote,omitempty"`
+	Plugin   string `yaml:"plugin,omitempty" json:"plugin,omitempty"`
+	Priority int    `yaml:"priority,omitempty" json:"priority,omitempty"`
+}
+
+//builtinParsers is the factory registry backing InitFromConfig's `name:`
+//entries, keyed by the same names Init registers them under.
+var builtinParsers = map[string]Parser{
+	"npm":            NpmParser,
+	"maven":          MavenParser,
+	"pypi":           PyPIParser,
+	"nuget":          NuGetParser,
+	"rubygems":       RubyGemsParser,
+	"harbor-catalog": HarborCatalogParser,
+	"harbor":         HarborParser,
+}
+
+//InitFromConfig resets pc and registers one parser per entry: a built-in
+//parser looked up by name, a remote gRPC parser, or a Go plugin. Entries
+//without an explicit Priority are ranked by position, earlier entries
+//first, so a config can mirror the fall-through order of Init without
+//spelling out priorities by hand.
+func InitFromConfig(pc *ParserChain, entries []ParserConfigEntry) error {
+	if err := pc.Close(); err != nil {
+		return err
+	}
+	pc.entries = nil
+
+	for i, entry := range entries {
+		priority := entry.Priority
+		if priority == 0 {
+			priority = len(entries) - i
+		}
+
+		switch {
+		case entry.Name != "":
+			parser, ok := builtinParsers[entry.Name]
+			if !ok {
+				return fmt.Errorf("unknown built-in parser %q", entry.Name)
+			}
+			if err := pc.RegisterWithPriority(entry.Name, parser, priority); err != nil {
+				return err
+			}
+		case entry.Remote != "":
+			if err := RegisterRemote(pc, "remote:"+entry.Remote, entry.Remote, priority); err != nil {
+				return err
+			}
+		case entry.Plugin != "":
+			if err := RegisterFromPlugin(pc, "plugin:"+entry.Plugin, entry.Plugin, priority); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("parser config entry %d has neither name, remote, nor plugin set", i)
+		}
+	}
+
+	return nil
+}
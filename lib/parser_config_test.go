@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//buildTestPlugin compiles a throwaway Go plugin exporting a Parser that
+//always reports registryType as a hit, and returns the path to the built
+//.so. It shells out to `go build -buildmode=plugin`, which needs network
+//access to resolve this module's dependencies via a local replace - skip
+//rather than fail if that isn't available in the sandbox running the test.
+func buildTestPlugin(t *testing.T, registryType string) string {
+	t.Helper()
+
+	repoRoot, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatalf("resolving repo root: %v", err)
+	}
+
+	dir := t.TempDir()
+	goMod := fmt.Sprintf(`module tempplugin
+
+go 1.21
+
+require github.com/reasonerjt/registry-factory v0.0.0
+
+replace github.com/reasonerjt/registry-factory => %s
+`, repoRoot)
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644); err != nil {
+		t.Fatalf("writing go.mod: %v", err)
+	}
+
+	mainGo := fmt.Sprintf(`package main
+
+import (
+	"net/http"
+
+	"github.com/reasonerjt/registry-factory/lib"
+)
+
+var Parser lib.Parser = func(req *http.Request) (lib.RequestMeta, error) {
+	return lib.RequestMeta{RegistryType: %q, HasHit: true}, nil
+}
+
+func main() {}
+`, registryType)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(mainGo), 0644); err != nil {
+		t.Fatalf("writing main.go: %v", err)
+	}
+
+	tidy := exec.Command("go", "mod", "tidy")
+	tidy.Dir = dir
+	if out, err := tidy.CombinedOutput(); err != nil {
+		t.Skipf("skipping: go mod tidy for test plugin failed (likely no network in this sandbox): %v\n%s", err, out)
+	}
+
+	soPath := filepath.Join(dir, "plugin.so")
+	build := exec.Command("go", "build", "-buildmode=plugin", "-o", soPath, ".")
+	build.Dir = dir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Skipf("skipping: building test plugin failed (likely no plugin build support in this sandbox): %v\n%s", err, out)
+	}
+
+	return soPath
+}
+
+//TestInitFromConfigDispatchesByEntryKind covers InitFromConfig's built-in
+//and remote entry kinds, and its default-priority-by-position rule for
+//entries that don't set Priority explicitly.
+func TestInitFromConfigDispatchesByEntryKind(t *testing.T) {
+	pc := &ParserChain{}
+	entries := []ParserConfigEntry{
+		{Name: "npm"},
+		{Remote: "127.0.0.1:0"},
+	}
+
+	if err := InitFromConfig(pc, entries); err != nil {
+		t.Fatalf("InitFromConfig: %v", err)
+	}
+	defer pc.Close()
+
+	if len(pc.entries) != 2 {
+		t.Fatalf("len(pc.entries) = %d, want 2", len(pc.entries))
+	}
+
+	wantNames := []string{"npm", "remote:127.0.0.1:0"}
+	wantPriorities := []int{2, 1}
+	for i, entry := range pc.entries {
+		if entry.Name != wantNames[i] {
+			t.Errorf("entries[%d].Name = %q, want %q", i, entry.Name, wantNames[i])
+		}
+		if entry.Priority != wantPriorities[i] {
+			t.Errorf("entries[%d].Priority = %d, want %d (position-based)", i, entry.Priority, wantPriorities[i])
+		}
+	}
+
+	if len(pc.closers) != 1 {
+		t.Fatalf("len(pc.closers) = %d, want 1 (the dialed remote connection)", len(pc.closers))
+	}
+}
+
+//TestInitFromConfigDispatchesPlugin covers InitFromConfig's `plugin:` entry
+//kind by building and loading a real .so. plugin.Open refuses to load a
+//plugin that imports a package already compiled into the current test
+//binary under a different build ID - which is exactly what happens when a
+//plugin built via a separate `go build` imports the lib package this test
+//binary already built in its "package lib" test variant - so this test
+//skips rather than fails when that inherent limitation is hit.
+func TestInitFromConfigDispatchesPlugin(t *testing.T) {
+	pluginPath := buildTestPlugin(t, "plugin-hit")
+
+	pc := &ParserChain{}
+	err := InitFromConfig(pc, []ParserConfigEntry{{Plugin: pluginPath}})
+	if err != nil {
+		if strings.Contains(err.Error(), "different version of package") {
+			t.Skipf("skipping: plugin.Open can't load a plugin importing a package this test binary already compiled: %v", err)
+		}
+		t.Fatalf("InitFromConfig: %v", err)
+	}
+
+	if len(pc.entries) != 1 || pc.entries[0].Name != "plugin:"+pluginPath {
+		t.Fatalf("entries = %+v, want a single plugin:%s entry", pc.entries, pluginPath)
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	meta, err := pc.entries[0].Parser(req)
+	if err != nil {
+		t.Fatalf("invoking loaded plugin parser: %v", err)
+	}
+	if meta.RegistryType != "plugin-hit" || !meta.HasHit {
+		t.Fatalf("plugin parser returned %+v, want a plugin-hit", meta)
+	}
+}
+
+//TestInitFromConfigUnknownBuiltin guards the error path for a `name:` entry
+//that doesn't match any registered built-in parser.
+func TestInitFromConfigUnknownBuiltin(t *testing.T) {
+	pc := &ParserChain{}
+	err := InitFromConfig(pc, []ParserConfigEntry{{Name: "does-not-exist"}})
+	if err == nil {
+		t.Fatal("expected an error for an unknown built-in parser name")
+	}
+}
+
+//TestInitFromConfigExplicitPriorityOverridesPosition guards that an entry
+//setting Priority explicitly is not overridden by position-based ranking.
+func TestInitFromConfigExplicitPriorityOverridesPosition(t *testing.T) {
+	pc := &ParserChain{}
+	entries := []ParserConfigEntry{
+		{Name: "npm", Priority: 42},
+		{Name: "harbor"},
+	}
+	if err := InitFromConfig(pc, entries); err != nil {
+		t.Fatalf("InitFromConfig: %v", err)
+	}
+
+	if pc.entries[0].Priority != 42 {
+		t.Fatalf("entries[0].Priority = %d, want 42 (explicit)", pc.entries[0].Priority)
+	}
+}
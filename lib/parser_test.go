@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//TestParseConcurrentTieBreakMatchesRegistrationOrder guards against the
+//concurrent path picking a winner by completion order instead of the
+//priority/registration-order rule parseSequential uses. Every parser here
+//shares a priority and reports a hit, and later-registered ones are made to
+//finish first - a tie-break keyed off channel-arrival order would
+//eventually pick one of them instead of the first-registered parser.
+func TestParseConcurrentTieBreakMatchesRegistrationOrder(t *testing.T) {
+	const n = 5
+
+	pc := &ParserChain{Concurrent: true}
+	for i := 0; i < n; i++ {
+		i := i
+		parser := func(req *http.Request) (RequestMeta, error) {
+			//Later-registered parsers resolve sooner, to try to provoke a
+			//first-arrival tie-break into picking the wrong winner.
+			time.Sleep(time.Duration(n-1-i) * time.Millisecond)
+			return RequestMeta{RegistryType: fmt.Sprintf("parser-%d", i), HasHit: true}, nil
+		}
+		if err := pc.RegisterWithPriority(fmt.Sprintf("p%d", i), parser, 10); err != nil {
+			t.Fatalf("RegisterWithPriority: %v", err)
+		}
+	}
+
+	for iter := 0; iter < 50; iter++ {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		meta, err := pc.Parse(context.Background(), req)
+		if err != nil {
+			t.Fatalf("iteration %d: Parse: %v", iter, err)
+		}
+		if meta.RegistryType != "parser-0" {
+			t.Fatalf("iteration %d: RegistryType = %q, want %q (registration-order tie-break)", iter, meta.RegistryType, "parser-0")
+		}
+	}
+}
+
+func TestRegisterWithPriorityRejectsNilParser(t *testing.T) {
+	pc := &ParserChain{}
+	if err := pc.RegisterWithPriority("nil-parser", nil, 1); err == nil {
+		t.Fatal("expected an error registering a nil parser")
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestParserChainClose(t *testing.T) {
+	pc := &ParserChain{}
+	closed := 0
+	pc.closers = append(pc.closers, closerFunc(func() error { closed++; return nil }))
+	pc.closers = append(pc.closers, closerFunc(func() error { closed++; return nil }))
+
+	if err := pc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if closed != 2 {
+		t.Fatalf("closed = %d, want 2", closed)
+	}
+	if len(pc.closers) != 0 {
+		t.Fatalf("closers not cleared after Close, got %d remaining", len(pc.closers))
+	}
+}
+
+func TestBufferBody(t *testing.T) {
+	req := httptest.NewRequest("POST", "http://example.com/", bytes.NewReader([]byte("hello world")))
+
+	buf, err := bufferBody(req, 1024)
+	if err != nil {
+		t.Fatalf("bufferBody: %v", err)
+	}
+	if string(buf) != "hello world" {
+		t.Fatalf("buffered body = %q, want %q", buf, "hello world")
+	}
+
+	remaining, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("reading rewound body: %v", err)
+	}
+	if string(remaining) != "hello world" {
+		t.Fatalf("req.Body after bufferBody = %q, want %q", remaining, "hello world")
+	}
+}
+
+func TestBufferBodyExceedsMax(t *testing.T) {
+	req := httptest.NewRequest("POST", "http://example.com/", bytes.NewReader([]byte("hello world")))
+
+	if _, err := bufferBody(req, 3); err == nil {
+		t.Fatal("expected an error for a body exceeding the max buffer size")
+	}
+}
+
+func TestApplyBodyReplacement(t *testing.T) {
+	req := httptest.NewRequest("PUT", "http://example.com/", bytes.NewReader([]byte("original")))
+
+	applyBodyReplacement(req, RequestMeta{BodyReplacement: []byte("replaced")})
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("reading replaced body: %v", err)
+	}
+	if string(body) != "replaced" {
+		t.Fatalf("req.Body = %q, want %q", body, "replaced")
+	}
+	if req.ContentLength != int64(len("replaced")) {
+		t.Fatalf("ContentLength = %d, want %d", req.ContentLength, len("replaced"))
+	}
+	if req.Header.Get("Content-Length") != fmt.Sprintf("%d", len("replaced")) {
+		t.Fatalf("Content-Length header = %q, want %q", req.Header.Get("Content-Length"), fmt.Sprintf("%d", len("replaced")))
+	}
+}
+
+func TestApplyBodyReplacementNoopWhenNil(t *testing.T) {
+	req := httptest.NewRequest("PUT", "http://example.com/", bytes.NewReader([]byte("original")))
+
+	applyBodyReplacement(req, RequestMeta{})
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(body) != "original" {
+		t.Fatalf("req.Body = %q, want untouched %q", body, "original")
+	}
+}
@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"fmt"
+	"plugin"
+)
+
+//RegisterFromPlugin loads a Go plugin (a `.so` built with
+//`go build -buildmode=plugin`) exporting a `Parser` symbol of type Parser,
+//and registers it on pc under name. This lets operators extend the factory
+//with site-specific registry protocols without forking this module.
+func RegisterFromPlugin(pc *ParserChain, name, path string, priority int) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening plugin %s: %w", path, err)
+	}
+
+	sym, err := p.Lookup("Parser")
+	if err != nil {
+		return fmt.Errorf("plugin %s does not export Parser: %w", path, err)
+	}
+
+	parser, ok := sym.(Parser)
+	if !ok {
+		parserPtr, isPtr := sym.(*Parser)
+		if !isPtr {
+			return fmt.Errorf("plugin %s Parser symbol has unexpected type %T", path, sym)
+		}
+		parser = *parserPtr
+	}
+
+	return pc.RegisterWithPriority(name, parser, priority)
+}
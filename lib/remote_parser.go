@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+//remoteParseMethod is the fully-qualified gRPC method a remote parser must
+//implement: ParseRequest(HTTPRequestMetadata) returns (RequestMeta).
+const remoteParseMethod = "/registryfactory.ParserService/ParseRequest"
+
+//remoteParseTimeout bounds how long RegisterRemote will wait for a remote
+//parser to answer a single request.
+const remoteParseTimeout = 5 * time.Second
+
+//maxRemoteBodyBytes caps how much of req.Body is shipped to a remote parser.
+const maxRemoteBodyBytes = 1 << 20 // 1MiB
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+//jsonCodec lets RegisterRemote talk to a ParserService over gRPC using
+//plain JSON messages instead of requiring generated protobuf types for
+//every site-specific parser an operator might stand up.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+//remoteHTTPRequest is the wire representation of the parts of *http.Request
+//sent to a remote parser: the request line, headers, and a capped body.
+type remoteHTTPRequest struct {
+	Method string              `json:"method"`
+	URL    string              `json:"url"`
+	Header map[string][]string `json:"header"`
+	Body   []byte              `json:"body,omitempty"`
+}
+
+//remoteRequestMeta is the wire representation of a RequestMeta returned by
+//a remote parser.
+type remoteRequestMeta struct {
+	RegistryType string            `json:"registry_type"`
+	HasHit       bool              `json:"has_hit"`
+	Metadata     map[string]string `json:"metadata"`
+}
+
+//RegisterRemote wraps a gRPC ParserService at addr as a Parser and
+//registers it on pc, so operators can run site-specific parsing logic as an
+//external process without forking this module. The request line, headers,
+//and a capped prefix of the body are sent to the remote ParseRequest RPC;
+//its RequestMeta is translated back into ours. The dialed connection is
+//tracked on pc and released by pc.Close.
+func RegisterRemote(pc *ParserChain, name, addr string, priority int, opts ...grpc.DialOption) error {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return err
+	}
+	pc.closers = append(pc.closers, conn)
+
+	return pc.RegisterWithPriority(name, remoteParser(conn), priority)
+}
+
+func remoteParser(conn *grpc.ClientConn) Parser {
+	return func(req *http.Request) (RequestMeta, error) {
+		body, err := bufferRemoteBody(req)
+		if err != nil {
+			return RequestMeta{}, err
+		}
+
+		reqMsg := &remoteHTTPRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: req.Header,
+			Body:   body,
+		}
+		replyMsg := &remoteRequestMeta{}
+
+		ctx, cancel := context.WithTimeout(req.Context(), remoteParseTimeout)
+		defer cancel()
+
+		if err := conn.Invoke(ctx, remoteParseMethod, reqMsg, replyMsg, grpc.CallContentSubtype("json")); err != nil {
+			return RequestMeta{}, err
+		}
+
+		return RequestMeta{
+			RegistryType: replyMsg.RegistryType,
+			HasHit:       replyMsg.HasHit,
+			Metadata:     replyMsg.Metadata,
+		}, nil
+	}
+}
+
+//bufferRemoteBody reads up to maxRemoteBodyBytes of req.Body and rewinds
+//req so it remains proxyable after the remote parser has been consulted.
+func bufferRemoteBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(req.Body, maxRemoteBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	//Put the bytes read back in front of whatever is still unread, so a body
+	//longer than the cap reaches the proxy whole.
+	req.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(buf), req.Body))
+
+	return buf, nil
+}